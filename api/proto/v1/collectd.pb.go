@@ -0,0 +1,42 @@
+// Package collectdv1 hand-implements the message types described by
+// api/proto/v1/collectd.proto. There is no protoc/protoc-gen-go in this
+// repo's build yet, so these are maintained by hand in lockstep with the
+// .proto file rather than generated; edit both together.
+package collectdv1
+
+import (
+	proto "github.com/golang/protobuf/proto"
+)
+
+// Sample is one flattened collectd data-source observation, decoded from a
+// single DS entry of a Values (0x0006) part.
+type Sample struct {
+	Host           string  `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	Plugin         string  `protobuf:"bytes,2,opt,name=plugin,proto3" json:"plugin,omitempty"`
+	PluginInstance string  `protobuf:"bytes,3,opt,name=plugin_instance,json=pluginInstance,proto3" json:"plugin_instance,omitempty"`
+	Type           string  `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	TypeInstance   string  `protobuf:"bytes,5,opt,name=type_instance,json=typeInstance,proto3" json:"type_instance,omitempty"`
+	DsName         string  `protobuf:"bytes,6,opt,name=ds_name,json=dsName,proto3" json:"ds_name,omitempty"`
+	DsType         string  `protobuf:"bytes,7,opt,name=ds_type,json=dsType,proto3" json:"ds_type,omitempty"`
+	Value          float64 `protobuf:"fixed64,8,opt,name=value,proto3" json:"value,omitempty"`
+	Time           int64   `protobuf:"varint,9,opt,name=time,proto3" json:"time,omitempty"`
+	Interval       int64   `protobuf:"varint,10,opt,name=interval,proto3" json:"interval,omitempty"`
+}
+
+func (m *Sample) Reset()         { *m = Sample{} }
+func (m *Sample) String() string { return proto.CompactTextString(m) }
+func (*Sample) ProtoMessage()    {}
+
+// Ack acknowledges a batch of samples pushed over a PushSamples stream.
+type Ack struct {
+	SamplesReceived int64 `protobuf:"varint,1,opt,name=samples_received,json=samplesReceived,proto3" json:"samples_received,omitempty"`
+}
+
+func (m *Ack) Reset()         { *m = Ack{} }
+func (m *Ack) String() string { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*Sample)(nil), "collectd.v1.Sample")
+	proto.RegisterType((*Ack)(nil), "collectd.v1.Ack")
+}
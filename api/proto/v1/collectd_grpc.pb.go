@@ -0,0 +1,106 @@
+// Hand-implements the gRPC client/server stubs for the CollectdExporter
+// service described by api/proto/v1/collectd.proto. Maintained by hand
+// alongside collectd.pb.go rather than generated; see that file's package
+// comment.
+package collectdv1
+
+import (
+	context "context"
+
+	grpc "google.golang.org/grpc"
+)
+
+// CollectdExporterClient is the client API for CollectdExporter service.
+type CollectdExporterClient interface {
+	PushSamples(ctx context.Context, opts ...grpc.CallOption) (CollectdExporter_PushSamplesClient, error)
+}
+
+type collectdExporterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCollectdExporterClient(cc grpc.ClientConnInterface) CollectdExporterClient {
+	return &collectdExporterClient{cc}
+}
+
+func (c *collectdExporterClient) PushSamples(ctx context.Context, opts ...grpc.CallOption) (CollectdExporter_PushSamplesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CollectdExporter_serviceDesc.Streams[0], "/collectd.v1.CollectdExporter/PushSamples", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &collectdExporterPushSamplesClient{stream}, nil
+}
+
+type CollectdExporter_PushSamplesClient interface {
+	Send(*Sample) error
+	CloseAndRecv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type collectdExporterPushSamplesClient struct {
+	grpc.ClientStream
+}
+
+func (x *collectdExporterPushSamplesClient) Send(m *Sample) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *collectdExporterPushSamplesClient) CloseAndRecv() (*Ack, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CollectdExporterServer is the server API for CollectdExporter service.
+type CollectdExporterServer interface {
+	PushSamples(CollectdExporter_PushSamplesServer) error
+}
+
+type CollectdExporter_PushSamplesServer interface {
+	SendAndClose(*Ack) error
+	Recv() (*Sample, error)
+	grpc.ServerStream
+}
+
+type collectdExporterPushSamplesServer struct {
+	grpc.ServerStream
+}
+
+func (x *collectdExporterPushSamplesServer) SendAndClose(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *collectdExporterPushSamplesServer) Recv() (*Sample, error) {
+	m := new(Sample)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CollectdExporter_PushSamples_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CollectdExporterServer).PushSamples(&collectdExporterPushSamplesServer{stream})
+}
+
+func RegisterCollectdExporterServer(s grpc.ServiceRegistrar, srv CollectdExporterServer) {
+	s.RegisterService(&_CollectdExporter_serviceDesc, srv)
+}
+
+var _CollectdExporter_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "collectd.v1.CollectdExporter",
+	HandlerType: (*CollectdExporterServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PushSamples",
+			Handler:       _CollectdExporter_PushSamples_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "api/proto/v1/collectd.proto",
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
+	"runtime"
 	"time"
 )
 
@@ -40,6 +44,13 @@ type Value struct {
 	AbsoluteValue int64
 }
 
+const (
+	DsTypeCounter  byte = 0
+	DsTypeGauge    byte = 1
+	DsTypeDerive   byte = 2
+	DsTypeAbsolute byte = 3
+)
+
 type Packet struct {
 	Host           StringPart
 	Time           NumericPart
@@ -83,7 +94,7 @@ func lowtime(packet *Packet, payload *bytes.Buffer) (err error) {
 	} else {
 		numericPart := NumericPart{PartHeaderFromBuffer(0x0001, payload), value}
 		packet.Time = numericPart
-		log.Printf("type = %d, length = %d, hostname = %s",
+		log.Printf("type = %d, length = %d, time = %d",
 			packet.Time.Header.PartType,
 			packet.Time.Header.PartLength,
 			packet.Time.Value)
@@ -153,16 +164,81 @@ func interval(packet *Packet, payload *bytes.Buffer) (err error) {
 	if readErr != nil {
 		return readErr
 	} else {
-		numericPart := NumericPart{PartHeaderFromBuffer(0x0008, payload), value}
+		numericPart := NumericPart{PartHeaderFromBuffer(0x0007, payload), value}
 		packet.Interval = numericPart
-		log.Printf("type = %d, length = %d, datevalue = %s",
+		log.Printf("type = %d, length = %d, interval = %d",
 			packet.Interval.Header.PartType,
 			packet.Interval.Header.PartLength,
-			time.Unix(packet.Interval.Value, 0))
+			packet.Interval.Value)
 		return nil
 	}
 }
 
+func intervalHigh(packet *Packet, payload *bytes.Buffer) (err error) {
+	var value int64
+	readErr := binary.Read(payload, binary.BigEndian, &value)
+	if readErr != nil {
+		return readErr
+	} else {
+		numericPart := NumericPart{PartHeaderFromBuffer(0x0009, payload), value}
+		packet.IntervalValue = numericPart
+		log.Printf("type = %d, length = %d, interval-high-resolution = %d",
+			packet.IntervalValue.Header.PartType,
+			packet.IntervalValue.Header.PartLength,
+			packet.IntervalValue.Value)
+		return nil
+	}
+}
+
+func values(packet *Packet, payload *bytes.Buffer) (err error) {
+	var numberOfValues uint16
+	readErr := binary.Read(payload, binary.BigEndian, &numberOfValues)
+	if readErr != nil {
+		return readErr
+	}
+
+	dsTypes := make([]byte, numberOfValues)
+	if _, err := io.ReadFull(payload, dsTypes); err != nil {
+		return err
+	}
+
+	parsedValues := make([]Value, numberOfValues)
+	for i, dsType := range dsTypes {
+		value := Value{DataType: dsType}
+		switch dsType {
+		case DsTypeCounter:
+			if err := binary.Read(payload, binary.BigEndian, &value.CounterValue); err != nil {
+				return err
+			}
+		case DsTypeGauge:
+			var bits uint64
+			if err := binary.Read(payload, binary.LittleEndian, &bits); err != nil {
+				return err
+			}
+			value.GaugeValue = math.Float64frombits(bits)
+		case DsTypeDerive:
+			if err := binary.Read(payload, binary.BigEndian, &value.DeriveValue); err != nil {
+				return err
+			}
+		case DsTypeAbsolute:
+			if err := binary.Read(payload, binary.BigEndian, &value.AbsoluteValue); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown ds-type %d for value %d", dsType, i)
+		}
+		parsedValues[i] = value
+	}
+
+	valuePart := ValuePart{PartHeaderFromBuffer(0x0006, payload), numberOfValues, parsedValues}
+	packet.Values = valuePart
+	log.Printf("type = %d, length = %d, number-of-values = %d",
+		packet.Values.Header.PartType,
+		packet.Values.Header.PartLength,
+		packet.Values.NumberOfValues)
+	return nil
+}
+
 func createMessageProcessors() (processors map[uint16]part) {
 
 	//Need to look at returning a touple here being the id the func is designed to work with
@@ -176,12 +252,112 @@ func createMessageProcessors() (processors map[uint16]part) {
 	messageProcessors[0x0003] = pluginInstance
 	messageProcessors[0x0004] = processType
 	messageProcessors[0x0005] = processTypeInstance
+	messageProcessors[0x0006] = values
+	messageProcessors[0x0007] = interval
+	messageProcessors[0x0009] = intervalHigh
 	return messageProcessors
 }
 
+// processDatagram walks the parts of a collectd datagram, dispatching each
+// to its registered processor. A signature or encrypted part is only
+// honored as the very first part of the datagram, matching how collectd
+// itself only ever emits one at the head of a packet: anything found
+// leading the signature would otherwise be dispatched before its HMAC is
+// even checked, so a non-leading signature/encrypted part drops the whole
+// datagram instead of being processed.
+func processDatagram(payloadBuffer *bytes.Buffer, packet *Packet, messageProcessors map[uint16]part, users map[string][]byte, registry *Registry, sink Sink) {
+	leading := true
+	for payloadBuffer.Len() > 0 {
+		partHeader := new(PartHeader)
+		binary.Read(payloadBuffer, binary.BigEndian, partHeader)
+		isLeading := leading
+		leading = false
+
+		switch partHeader.PartType {
+		case partSignature:
+			if !isLeading {
+				log.Printf("dropping datagram: signature part must lead the datagram")
+				return
+			}
+			signaturePayload := bytes.NewBuffer(payloadBuffer.Next(int(partHeader.PartLength) - 4))
+			rest := payloadBuffer.Bytes()
+			ok, err := verifySignature(signaturePayload, rest, users)
+			if err != nil {
+				log.Printf("signature verification error: %v", err)
+				return
+			}
+			if !ok {
+				log.Printf("signature verification failed, dropping packet")
+				return
+			}
+			continue
+		case partEncrypted:
+			if !isLeading {
+				log.Printf("dropping datagram: encrypted part must lead the datagram")
+				return
+			}
+			encryptedPayload := bytes.NewBuffer(payloadBuffer.Next(int(partHeader.PartLength) - 4))
+			decrypted, err := decryptPart(encryptedPayload, users)
+			if err != nil {
+				log.Printf("decryption error: %v", err)
+				return
+			}
+			processDatagram(decrypted, packet, messageProcessors, users, registry, sink)
+			continue
+		}
+
+		partBuffer := bytes.NewBuffer(payloadBuffer.Next(int(partHeader.PartLength) - 4))
+		processor, supports := messageProcessors[partHeader.PartType]
+		if supports {
+			err := processor(packet, partBuffer)
+			if err != nil {
+				log.Printf("dropping datagram: error processing part type %d: %v", partHeader.PartType, err)
+				return
+			}
+			if partHeader.PartType == 0x0006 {
+				if registry != nil {
+					registry.Observe(packet)
+				}
+				if sink != nil {
+					for _, sample := range samplesFromPacket(packet) {
+						if err := sink.Emit(sample); err != nil {
+							log.Printf("sink emit error: %v", err)
+						}
+					}
+				}
+			}
+		} else {
+			fmt.Print(".")
+		}
+	}
+	fmt.Print("\n")
+}
+
+var (
+	workers    = flag.Int("workers", runtime.NumCPU(), "number of datagram decode workers")
+	sinkKind   = flag.String("sink", "log", "output sink: log, stdout-json or grpc")
+	grpcTarget = flag.String("grpc-target", "", "target address for the grpc sink")
+)
+
 func main() {
+	flag.Parse()
+
+	sink, err := NewSink(*sinkKind, *grpcTarget)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	messageProcessors := createMessageProcessors()
 
+	users, err := loadUsers("users.conf")
+	if err != nil {
+		log.Printf("no users.conf loaded, signed/encrypted packets will be rejected: %v", err)
+		users = make(map[string][]byte)
+	}
+
+	registry := NewRegistry()
+	ServeMetrics(":9103")
+
 	uaddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", 5555))
 	if err != nil {
 		log.Fatal(err)
@@ -192,33 +368,5 @@ func main() {
 	}
 	defer conn.Close()
 
-	packet := new(Packet)
-	packetBytes := make([]byte, UDP_PACKET_SIZE)
-
-	for {
-		numOfBytesReceived, _, err := conn.ReadFromUDP(packetBytes)
-		packetBytes = packetBytes[0:numOfBytesReceived]
-
-		if err != nil {
-			log.Fatal(err)
-		}
-		buffer := bytes.NewBuffer(packetBytes)
-		go func(payloadBuffer *bytes.Buffer) {
-			for payloadBuffer.Len() > 0 {
-				partHeader := new(PartHeader)
-				binary.Read(payloadBuffer, binary.BigEndian, partHeader)
-				partBuffer := bytes.NewBuffer(payloadBuffer.Next(int(partHeader.PartLength) - 4))
-				processor, supports := messageProcessors[partHeader.PartType]
-				if supports {
-					err := processor(packet, partBuffer)
-					if err != nil {
-						log.Fatal(err)
-					}
-				} else {
-					fmt.Print(".")
-				}
-			}
-			fmt.Print("\n")
-		}(buffer)
-	}
-}
\ No newline at end of file
+	runWorkerPool(conn, *workers, messageProcessors, users, registry, sink)
+}
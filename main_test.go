@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestValuesDecodesEachDsType(t *testing.T) {
+	payload := bytes.NewBuffer(buildValuesPayload(
+		[]byte{DsTypeCounter, DsTypeGauge, DsTypeDerive, DsTypeAbsolute},
+		[]float64{42, 3.14, -7, 99},
+	))
+
+	packet := new(Packet)
+	if err := values(packet, payload); err != nil {
+		t.Fatalf("values() returned error: %v", err)
+	}
+
+	got := packet.Values.Values
+	if len(got) != 4 {
+		t.Fatalf("got %d values, want 4", len(got))
+	}
+	if got[0].CounterValue != 42 {
+		t.Errorf("counter value = %d, want 42", got[0].CounterValue)
+	}
+	if got[1].GaugeValue != 3.14 {
+		t.Errorf("gauge value = %v, want 3.14", got[1].GaugeValue)
+	}
+	if got[2].DeriveValue != -7 {
+		t.Errorf("derive value = %d, want -7", got[2].DeriveValue)
+	}
+	if got[3].AbsoluteValue != 99 {
+		t.Errorf("absolute value = %d, want 99", got[3].AbsoluteValue)
+	}
+}
+
+func TestValuesRejectsTruncatedDsTypes(t *testing.T) {
+	// NumberOfValues claims 4 ds-type bytes but only 1 is actually present;
+	// a buggy reader would zero-pad the rest instead of erroring.
+	full := buildValuesPayload([]byte{DsTypeCounter}, []float64{1})
+	binary.BigEndian.PutUint16(full[0:2], 4)
+	payload := bytes.NewBuffer(full)
+
+	packet := new(Packet)
+	if err := values(packet, payload); err == nil {
+		t.Fatal("values() accepted a datagram with fewer ds-type bytes than NumberOfValues claims")
+	}
+}
+
+func TestValuesRejectsUnknownDsType(t *testing.T) {
+	const unknownDsType = 0x7F
+	payload := bytes.NewBuffer(buildValuesPayload([]byte{unknownDsType}, []float64{1}))
+
+	packet := new(Packet)
+	if err := values(packet, payload); err == nil {
+		t.Fatal("values() returned no error for an unknown ds-type")
+	}
+}
+
+func TestProcessDatagramDropsDatagramOnDecodeError(t *testing.T) {
+	const unknownDsType = 0x7F
+	datagram := buildPart(0x0006, buildValuesPayload([]byte{unknownDsType}, []float64{1}))
+	datagram = append(datagram, buildPart(0x0000, buildStringPayload("host-after-bad-part"))...)
+
+	packet := new(Packet)
+	processDatagram(bytes.NewBuffer(datagram), packet, createMessageProcessors(), nil, nil, nil)
+
+	if packet.Host.Value != "" {
+		t.Errorf("expected the datagram to be dropped before the trailing hostname part, got %q", packet.Host.Value)
+	}
+}
+
+func BenchmarkDispatchWorkerPool(b *testing.B) {
+	processors := createMessageProcessors()
+	datagram := buildDatagram("bench-host", "cpu", "gauge", 10, DsTypeGauge, 42)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			packet := new(Packet)
+			buffer := bytes.NewBuffer(append([]byte(nil), datagram...))
+			processDatagram(buffer, packet, processors, nil, nil, nil)
+		}
+	})
+}
+
+// BenchmarkDispatchPerPacketGoroutine reproduces the pre-chunk0-4 dispatch
+// pattern - one goroutine per datagram sharing a single *Packet - purely as
+// a throughput baseline; production code no longer does this.
+func BenchmarkDispatchPerPacketGoroutine(b *testing.B) {
+	processors := createMessageProcessors()
+	datagram := buildDatagram("bench-host", "cpu", "gauge", 10, DsTypeGauge, 42)
+	packet := new(Packet)
+
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		buffer := bytes.NewBuffer(append([]byte(nil), datagram...))
+		go func(buf *bytes.Buffer) {
+			defer wg.Done()
+			processDatagram(buf, packet, processors, nil, nil, nil)
+		}(buffer)
+	}
+	wg.Wait()
+}
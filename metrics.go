@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricNamespace = "collectd"
+
+// metricKey identifies one time series within the registry, independent of
+// the label values (host/plugin-instance/type-instance) it is observed with.
+type metricKey struct {
+	Plugin string
+	Type   string
+	DsName string
+}
+
+// seenLabels remembers when a particular label combination was last
+// observed, so it can be swept away once it goes stale.
+type seenLabels struct {
+	labels []string
+	at     time.Time
+}
+
+// metricEntry pairs a lazily-registered vector with the label combinations
+// currently reporting into it.
+type metricEntry struct {
+	counter *prometheus.CounterVec
+	gauge   *prometheus.GaugeVec
+	seen    map[string]seenLabels
+}
+
+// Registry converts collectd samples into Prometheus metrics, registering a
+// CounterVec/GaugeVec per (plugin, type, ds_name) the first time it is seen
+// and expiring label combinations that go quiet for too many intervals.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[metricKey]*metricEntry
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[metricKey]*metricEntry),
+	}
+}
+
+func dsName(index int) string {
+	return fmt.Sprintf("value%d", index)
+}
+
+var labelNames = []string{"instance", "plugin", "plugin_instance", "type", "type_instance"}
+
+func labelValues(packet *Packet) []string {
+	return []string{
+		packet.Host.Value,
+		packet.Plugin.Value,
+		packet.PluginInstance.Value,
+		packet.Type.Value,
+		packet.TypeInstance.Value,
+	}
+}
+
+// Observe records every value carried by packet's Values part, lazily
+// registering the underlying Prometheus vector on first use, and expires
+// any label combination that has gone quiet for too many intervals.
+func (r *Registry) Observe(packet *Packet) {
+	labels := labelValues(packet)
+	labelKey := strings.Join(labels, "\x1f")
+	now := time.Now()
+
+	for i, value := range packet.Values.Values {
+		key := metricKey{
+			Plugin: packet.Plugin.Value,
+			Type:   packet.Type.Value,
+			DsName: dsName(i),
+		}
+
+		r.mu.Lock()
+		entry, ok := r.metrics[key]
+		if !ok {
+			entry = r.newEntry(key, value.DataType)
+			r.metrics[key] = entry
+		}
+		entry.seen[labelKey] = seenLabels{labels: labels, at: now}
+		r.mu.Unlock()
+
+		switch value.DataType {
+		case DsTypeGauge:
+			entry.gauge.WithLabelValues(labels...).Set(value.GaugeValue)
+		case DsTypeCounter:
+			entry.counter.WithLabelValues(labels...).Add(float64(value.CounterValue))
+		case DsTypeAbsolute:
+			entry.counter.WithLabelValues(labels...).Add(float64(value.AbsoluteValue))
+		case DsTypeDerive:
+			entry.counter.WithLabelValues(labels...).Add(float64(value.DeriveValue))
+		}
+	}
+
+	r.expireStale(time.Duration(packet.Interval.Value) * time.Second)
+}
+
+// escapeMetricComponent doubles underscores within a single key component
+// before it is joined with "_" into a metric name, so that underscores
+// inside a component are told apart from the join: "a" + "b_c" and
+// "a_b" + "c" escape to "a_b__c" and "a__b_c" respectively rather than
+// colliding on the same name. Components that begin or end with "_" can
+// still collide ("a_" + "b" and "a" + "_b" both give "a___b").
+func escapeMetricComponent(s string) string {
+	return strings.ReplaceAll(s, "_", "__")
+}
+
+func metricName(key metricKey) string {
+	return fmt.Sprintf("%s_%s_%s",
+		escapeMetricComponent(key.Plugin),
+		escapeMetricComponent(key.Type),
+		escapeMetricComponent(key.DsName))
+}
+
+func (r *Registry) newEntry(key metricKey, dsType byte) *metricEntry {
+	name := metricName(key)
+	entry := &metricEntry{seen: make(map[string]seenLabels)}
+
+	if dsType == DsTypeGauge {
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      name,
+		}, labelNames)
+		entry.gauge = registerGaugeVec(gauge)
+		return entry
+	}
+
+	counterName := name
+	if dsType == DsTypeDerive {
+		counterName = name + "_total"
+	}
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Name:      counterName,
+	}, labelNames)
+	entry.counter = registerCounterVec(counter)
+	return entry
+}
+
+// registerGaugeVec registers vec, or returns the already-registered vector
+// for the same name if one races in ahead of it. Falling back instead of
+// panicking keeps one malformed-but-colliding sample from taking down the
+// whole receiver.
+func registerGaugeVec(vec *prometheus.GaugeVec) *prometheus.GaugeVec {
+	if err := prometheus.Register(vec); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.GaugeVec); ok {
+				return existing
+			}
+		}
+		log.Printf("metrics: failed to register %v: %v", vec, err)
+	}
+	return vec
+}
+
+// registerCounterVec is registerGaugeVec's counterpart for CounterVecs.
+func registerCounterVec(vec *prometheus.CounterVec) *prometheus.CounterVec {
+	if err := prometheus.Register(vec); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing
+			}
+		}
+		log.Printf("metrics: failed to register %v: %v", vec, err)
+	}
+	return vec
+}
+
+// staleAfter is how many missed intervals a label combination tolerates
+// before its series is deleted from the registry.
+const staleAfter = 3
+
+// expireStale drops label combinations across every metric that have not
+// been observed for more than staleAfter missed intervals. interval <= 0
+// means the packet carried no Interval part, so there is nothing to expire
+// against yet.
+func (r *Registry) expireStale(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ttl := interval * staleAfter
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range r.metrics {
+		for labelKey, seen := range entry.seen {
+			if now.Sub(seen.at) <= ttl {
+				continue
+			}
+			if entry.gauge != nil {
+				entry.gauge.DeleteLabelValues(seen.labels...)
+			}
+			if entry.counter != nil {
+				entry.counter.DeleteLabelValues(seen.labels...)
+			}
+			delete(entry.seen, labelKey)
+		}
+	}
+}
+
+// ServeMetrics starts the Prometheus scrape endpoint on addr, serving the
+// default registry's /metrics handler.
+func ServeMetrics(addr string) {
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			panic(err)
+		}
+	}()
+}
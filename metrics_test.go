@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestMetricNameEscapesJoinBoundary(t *testing.T) {
+	a := metricName(metricKey{Plugin: "a", Type: "b_c", DsName: "value0"})
+	b := metricName(metricKey{Plugin: "a_b", Type: "c", DsName: "value0"})
+
+	if a == b {
+		t.Fatalf("distinct (plugin, type) pairs produced the same metric name %q", a)
+	}
+}
+
+func TestRegistryObserveDoesNotPanicOnCollidingNames(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Registry.Observe panicked: %v", r)
+		}
+	}()
+
+	registry := NewRegistry()
+
+	first := new(Packet)
+	first.Plugin.Value = "metricstest-a"
+	first.Type.Value = "b_c"
+	first.Values.Values = []Value{{DataType: DsTypeGauge, GaugeValue: 1}}
+
+	second := new(Packet)
+	second.Plugin.Value = "metricstest-a_b"
+	second.Type.Value = "c"
+	second.Values.Values = []Value{{DataType: DsTypeGauge, GaugeValue: 2}}
+
+	registry.Observe(first)
+	registry.Observe(second)
+}
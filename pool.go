@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sync"
+)
+
+// datagram is one received UDP packet awaiting decode.
+type datagram struct {
+	addr *net.UDPAddr
+	buf  []byte
+}
+
+// datagramPool recycles UDP_PACKET_SIZE byte slices across datagrams so the
+// worker pool doesn't allocate one per packet.
+var datagramPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, UDP_PACKET_SIZE)
+	},
+}
+
+// runWorkerPool starts a single reader goroutine that reads datagrams off
+// conn into pooled buffers and a fixed pool of worker goroutines that
+// decode them. Each worker allocates a fresh *Packet per datagram so that
+// collectd's "sticky identity" rule (unchanged string parts carry over to
+// later value parts within one datagram) cannot leak identity fields
+// between datagrams decoded by different workers.
+func runWorkerPool(conn *net.UDPConn, workers int, messageProcessors map[uint16]part, users map[string][]byte, registry *Registry, sink Sink) {
+	jobs := make(chan datagram, workers*4)
+
+	go func() {
+		for {
+			buf := datagramPool.Get().([]byte)
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				log.Fatal(err)
+			}
+			jobs <- datagram{addr: addr, buf: buf[:n]}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				packet := new(Packet)
+				buffer := bytes.NewBuffer(d.buf)
+				processDatagram(buffer, packet, messageProcessors, users, registry, sink)
+				datagramPool.Put(d.buf[:cap(d.buf)])
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	partSignature = 0x0200
+	partEncrypted = 0x0210
+)
+
+// loadUsers reads a collectd-style users.conf file mapping usernames to
+// shared secrets, one "username: password" pair per line.
+func loadUsers(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed users.conf line: %q", line)
+		}
+		users[strings.TrimSpace(parts[0])] = []byte(strings.TrimSpace(parts[1]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// verifySignature checks the HMAC-SHA-256 signature carried in a 0x0200
+// part against the remainder of the datagram that follows it.
+func verifySignature(payload *bytes.Buffer, rest []byte, users map[string][]byte) (bool, error) {
+	if payload.Len() < 32 {
+		return false, fmt.Errorf("signature part too short")
+	}
+	signature := make([]byte, 32)
+	if _, err := payload.Read(signature); err != nil {
+		return false, err
+	}
+	username := payload.String()
+
+	secret, known := users[username]
+	if !known {
+		return false, fmt.Errorf("unknown username %q", username)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(username))
+	mac.Write(rest)
+	expected := mac.Sum(nil)
+
+	return hmac.Equal(signature, expected), nil
+}
+
+// decryptPart decrypts a 0x0210 encrypted part with AES-256-CFB, keyed by
+// the SHA-256 of the sending user's password, and verifies the embedded
+// SHA-1 checksum of the plaintext.
+func decryptPart(payload *bytes.Buffer, users map[string][]byte) (*bytes.Buffer, error) {
+	var usernameLength uint16
+	if err := binary.Read(payload, binary.BigEndian, &usernameLength); err != nil {
+		return nil, err
+	}
+	usernameBytes := make([]byte, usernameLength)
+	if _, err := io.ReadFull(payload, usernameBytes); err != nil {
+		return nil, err
+	}
+	username := string(usernameBytes)
+
+	secret, known := users[username]
+	if !known {
+		return nil, fmt.Errorf("unknown username %q", username)
+	}
+
+	iv := make([]byte, 16)
+	if _, err := io.ReadFull(payload, iv); err != nil {
+		return nil, err
+	}
+	checksum := make([]byte, 20)
+	if _, err := io.ReadFull(payload, checksum); err != nil {
+		return nil, err
+	}
+
+	ciphertext := payload.Bytes()
+	plaintext := make([]byte, len(ciphertext))
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCFBDecrypter(block, iv)
+	stream.XORKeyStream(plaintext, ciphertext)
+
+	sum := sha1.Sum(plaintext)
+	if !bytes.Equal(sum[:], checksum) {
+		return nil, fmt.Errorf("checksum mismatch for user %q", username)
+	}
+
+	return bytes.NewBuffer(plaintext), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+func TestVerifySignatureAcceptsValidHMAC(t *testing.T) {
+	users := map[string][]byte{"alice": []byte("sekrit")}
+	rest := []byte("the rest of the datagram")
+
+	mac := hmac.New(sha256.New, users["alice"])
+	mac.Write([]byte("alice"))
+	mac.Write(rest)
+	signature := mac.Sum(nil)
+
+	payload := bytes.NewBuffer(append(append([]byte{}, signature...), []byte("alice")...))
+
+	ok, err := verifySignature(payload, rest, users)
+	if err != nil {
+		t.Fatalf("verifySignature returned error: %v", err)
+	}
+	if !ok {
+		t.Fatal("verifySignature rejected a validly signed datagram")
+	}
+}
+
+func TestVerifySignatureRejectsTamperedPayload(t *testing.T) {
+	users := map[string][]byte{"alice": []byte("sekrit")}
+	rest := []byte("the rest of the datagram")
+
+	mac := hmac.New(sha256.New, users["alice"])
+	mac.Write([]byte("alice"))
+	mac.Write(rest)
+	signature := mac.Sum(nil)
+
+	payload := bytes.NewBuffer(append(append([]byte{}, signature...), []byte("alice")...))
+	tamperedRest := []byte("the rest of the datagram, tampered")
+
+	ok, err := verifySignature(payload, tamperedRest, users)
+	if err != nil {
+		t.Fatalf("verifySignature returned error: %v", err)
+	}
+	if ok {
+		t.Fatal("verifySignature accepted a tampered datagram")
+	}
+}
+
+func encryptForTest(t *testing.T, username string, secret, plaintext []byte) []byte {
+	t.Helper()
+
+	iv := make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	checksum := sha1.Sum(plaintext)
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext, plaintext)
+
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.BigEndian, uint16(len(username)))
+	payload.WriteString(username)
+	payload.Write(iv)
+	payload.Write(checksum[:])
+	payload.Write(ciphertext)
+	return payload.Bytes()
+}
+
+func TestDecryptPartRoundTrips(t *testing.T) {
+	users := map[string][]byte{"alice": []byte("sekrit")}
+	plaintext := []byte("a fully-populated collectd part")
+
+	payload := bytes.NewBuffer(encryptForTest(t, "alice", users["alice"], plaintext))
+
+	decrypted, err := decryptPart(payload, users)
+	if err != nil {
+		t.Fatalf("decryptPart returned error: %v", err)
+	}
+	if decrypted.String() != string(plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted.String(), plaintext)
+	}
+}
+
+func TestDecryptPartRejectsChecksumMismatch(t *testing.T) {
+	users := map[string][]byte{"alice": []byte("sekrit")}
+	plaintext := []byte("a fully-populated collectd part")
+
+	raw := encryptForTest(t, "alice", users["alice"], plaintext)
+	// Flip a byte in the ciphertext without touching the checksum.
+	raw[len(raw)-1] ^= 0xFF
+
+	if _, err := decryptPart(bytes.NewBuffer(raw), users); err == nil {
+		t.Fatal("decryptPart accepted ciphertext with a mismatched checksum")
+	}
+}
+
+func TestDecryptPartRejectsUnknownUser(t *testing.T) {
+	users := map[string][]byte{"alice": []byte("sekrit")}
+	raw := encryptForTest(t, "mallory", []byte("whatever"), []byte("payload"))
+
+	if _, err := decryptPart(bytes.NewBuffer(raw), users); err == nil {
+		t.Fatal("decryptPart accepted an unknown username")
+	}
+}
+
+// TestDecryptPartRejectsTruncation covers the three fixed-size reads in
+// decryptPart (username, IV, checksum): a buffer that runs out partway
+// through any of them must be rejected rather than silently zero-padded.
+func TestDecryptPartRejectsTruncation(t *testing.T) {
+	users := map[string][]byte{"alice": []byte("sekrit")}
+	raw := encryptForTest(t, "alice", users["alice"], []byte("a fully-populated collectd part"))
+
+	usernameLen := 2 + len("alice")
+	cases := map[string]int{
+		"mid-username": usernameLen - 1,
+		"mid-iv":       usernameLen + 8,
+		"mid-checksum": usernameLen + 16 + 10,
+	}
+
+	for name, cut := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decryptPart(bytes.NewBuffer(raw[:cut]), users); err == nil {
+				t.Fatalf("decryptPart accepted a buffer truncated %s", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	collectdv1 "github.com/reaandrew/learn-go/api/proto/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Sample is a flattened collectd data-source observation, one per DS entry
+// of a Values part, ready to hand to a Sink.
+type Sample struct {
+	Host           string
+	Plugin         string
+	PluginInstance string
+	Type           string
+	TypeInstance   string
+	DsName         string
+	DsType         string
+	Value          float64
+	Time           int64
+	Interval       int64
+}
+
+// Sink is where decoded samples go once a Values part has been parsed.
+type Sink interface {
+	Emit(sample Sample) error
+}
+
+func dsTypeName(dsType byte) string {
+	switch dsType {
+	case DsTypeCounter:
+		return "counter"
+	case DsTypeGauge:
+		return "gauge"
+	case DsTypeDerive:
+		return "derive"
+	case DsTypeAbsolute:
+		return "absolute"
+	default:
+		return "unknown"
+	}
+}
+
+func dsValue(value Value) float64 {
+	switch value.DataType {
+	case DsTypeCounter:
+		return float64(value.CounterValue)
+	case DsTypeGauge:
+		return value.GaugeValue
+	case DsTypeDerive:
+		return float64(value.DeriveValue)
+	case DsTypeAbsolute:
+		return float64(value.AbsoluteValue)
+	default:
+		return 0
+	}
+}
+
+// samplesFromPacket flattens every DS entry of packet's Values part into an
+// independent Sample.
+func samplesFromPacket(packet *Packet) []Sample {
+	values := packet.Values.Values
+	samples := make([]Sample, len(values))
+	for i, value := range values {
+		samples[i] = Sample{
+			Host:           packet.Host.Value,
+			Plugin:         packet.Plugin.Value,
+			PluginInstance: packet.PluginInstance.Value,
+			Type:           packet.Type.Value,
+			TypeInstance:   packet.TypeInstance.Value,
+			DsName:         dsName(i),
+			DsType:         dsTypeName(value.DataType),
+			Value:          dsValue(value),
+			Time:           packet.Time.Value,
+			Interval:       packet.Interval.Value,
+		}
+	}
+	return samples
+}
+
+// NewSink builds the Sink selected by kind ("log", "stdout-json" or
+// "grpc"). target is only used by the grpc sink.
+func NewSink(kind string, target string) (Sink, error) {
+	switch kind {
+	case "", "log":
+		return logSink{}, nil
+	case "stdout-json":
+		return stdoutJSONSink{}, nil
+	case "grpc":
+		return newGRPCSink(target, 100, time.Second), nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q", kind)
+	}
+}
+
+// logSink writes one log line per sample, in addition to the per-part lines
+// the processors already log as they decode.
+type logSink struct{}
+
+func (logSink) Emit(sample Sample) error {
+	log.Printf("sample host=%s plugin=%s/%s type=%s/%s ds=%s(%s) value=%v",
+		sample.Host, sample.Plugin, sample.PluginInstance,
+		sample.Type, sample.TypeInstance, sample.DsName, sample.DsType, sample.Value)
+	return nil
+}
+
+// stdoutJSONSink writes one JSON object per sample to stdout.
+type stdoutJSONSink struct{}
+
+func (stdoutJSONSink) Emit(sample Sample) error {
+	encoder := json.NewEncoder(os.Stdout)
+	return encoder.Encode(sample)
+}
+
+// grpcSink batches samples and pushes them to a remote collector over the
+// CollectdExporter.PushSamples client-streaming RPC. Exponential backoff
+// applies only when grpc.NewClient itself fails; a failed stream drops the
+// connection and the batch, and the next flush dials again without delay.
+type grpcSink struct {
+	target        string
+	batchSize     int
+	batchInterval time.Duration
+
+	mu      sync.Mutex
+	pending []Sample
+
+	connMu     sync.Mutex
+	conn       *grpc.ClientConn
+	client     collectdv1.CollectdExporterClient
+	backoff    time.Duration
+	nextDialAt time.Time
+}
+
+const (
+	grpcMinBackoff = time.Second
+	grpcMaxBackoff = 30 * time.Second
+)
+
+func newGRPCSink(target string, batchSize int, batchInterval time.Duration) *grpcSink {
+	sink := &grpcSink{target: target, batchSize: batchSize, batchInterval: batchInterval}
+	go sink.flushLoop()
+	return sink
+}
+
+func (s *grpcSink) Emit(sample Sample) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, sample)
+	shouldFlush := len(s.pending) >= s.batchSize
+	s.mu.Unlock()
+
+	if shouldFlush {
+		s.flush()
+	}
+	return nil
+}
+
+func (s *grpcSink) flushLoop() {
+	ticker := time.NewTicker(s.batchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.flush()
+	}
+}
+
+func (s *grpcSink) flush() {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	client, err := s.clientConn()
+	if err != nil {
+		log.Printf("grpc sink: %v", err)
+		return
+	}
+
+	stream, err := client.PushSamples(context.Background())
+	if err != nil {
+		log.Printf("grpc sink: %v", err)
+		s.invalidate()
+		return
+	}
+	for _, sample := range batch {
+		if err := stream.Send(toProtoSample(sample)); err != nil {
+			log.Printf("grpc sink: %v", err)
+			s.invalidate()
+			return
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		log.Printf("grpc sink: %v", err)
+		s.invalidate()
+	}
+}
+
+func (s *grpcSink) clientConn() (collectdv1.CollectdExporterClient, error) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+	if time.Now().Before(s.nextDialAt) {
+		return nil, fmt.Errorf("backing off reconnect until %s", s.nextDialAt)
+	}
+
+	conn, err := grpc.NewClient(s.target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		s.backoff = nextBackoff(s.backoff)
+		s.nextDialAt = time.Now().Add(s.backoff)
+		return nil, err
+	}
+	s.conn = conn
+	s.client = collectdv1.NewCollectdExporterClient(conn)
+	s.backoff = 0
+	return s.client, nil
+}
+
+func (s *grpcSink) invalidate() {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.client = nil
+}
+
+func nextBackoff(current time.Duration) time.Duration {
+	if current == 0 {
+		return grpcMinBackoff
+	}
+	next := current * 2
+	if next > grpcMaxBackoff {
+		return grpcMaxBackoff
+	}
+	return next
+}
+
+func toProtoSample(sample Sample) *collectdv1.Sample {
+	return &collectdv1.Sample{
+		Host:           sample.Host,
+		Plugin:         sample.Plugin,
+		PluginInstance: sample.PluginInstance,
+		Type:           sample.Type,
+		TypeInstance:   sample.TypeInstance,
+		DsName:         sample.DsName,
+		DsType:         sample.DsType,
+		Value:          sample.Value,
+		Time:           sample.Time,
+		Interval:       sample.Interval,
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	collectdv1 "github.com/reaandrew/learn-go/api/proto/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewSinkDispatch(t *testing.T) {
+	cases := []struct {
+		kind    string
+		wantErr bool
+		check   func(t *testing.T, s Sink)
+	}{
+		{kind: "", check: func(t *testing.T, s Sink) {
+			if _, ok := s.(logSink); !ok {
+				t.Errorf("sink = %T, want logSink", s)
+			}
+		}},
+		{kind: "log", check: func(t *testing.T, s Sink) {
+			if _, ok := s.(logSink); !ok {
+				t.Errorf("sink = %T, want logSink", s)
+			}
+		}},
+		{kind: "stdout-json", check: func(t *testing.T, s Sink) {
+			if _, ok := s.(stdoutJSONSink); !ok {
+				t.Errorf("sink = %T, want stdoutJSONSink", s)
+			}
+		}},
+		{kind: "grpc", check: func(t *testing.T, s Sink) {
+			if _, ok := s.(*grpcSink); !ok {
+				t.Errorf("sink = %T, want *grpcSink", s)
+			}
+		}},
+		{kind: "bogus", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.kind, func(t *testing.T) {
+			sink, err := NewSink(c.kind, "127.0.0.1:0")
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("NewSink returned no error for an unknown kind")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSink returned error: %v", err)
+			}
+			c.check(t, sink)
+		})
+	}
+}
+
+func TestSamplesFromPacketFlattensEachValue(t *testing.T) {
+	packet := new(Packet)
+	packet.Host.Value = "h"
+	packet.Plugin.Value = "p"
+	packet.PluginInstance.Value = "pi"
+	packet.Type.Value = "t"
+	packet.TypeInstance.Value = "ti"
+	packet.Time.Value = 100
+	packet.Interval.Value = 10
+	packet.Values.Values = []Value{
+		{DataType: DsTypeGauge, GaugeValue: 1.5},
+		{DataType: DsTypeCounter, CounterValue: 7},
+		{DataType: DsTypeDerive, DeriveValue: -3},
+		{DataType: DsTypeAbsolute, AbsoluteValue: 9},
+	}
+
+	samples := samplesFromPacket(packet)
+	want := []struct {
+		dsName string
+		dsType string
+		value  float64
+	}{
+		{"value0", "gauge", 1.5},
+		{"value1", "counter", 7},
+		{"value2", "derive", -3},
+		{"value3", "absolute", 9},
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(samples), len(want))
+	}
+	for i, w := range want {
+		s := samples[i]
+		if s.Host != "h" || s.Plugin != "p" || s.PluginInstance != "pi" || s.Type != "t" || s.TypeInstance != "ti" {
+			t.Errorf("sample %d identity fields = %+v", i, s)
+		}
+		if s.Time != 100 || s.Interval != 10 {
+			t.Errorf("sample %d time/interval = %+v", i, s)
+		}
+		if s.DsName != w.dsName || s.DsType != w.dsType || s.Value != w.value {
+			t.Errorf("sample %d = %+v, want dsName=%s dsType=%s value=%v", i, s, w.dsName, w.dsType, w.value)
+		}
+	}
+}
+
+func TestDsTypeNameUnknown(t *testing.T) {
+	if got := dsTypeName(0xFF); got != "unknown" {
+		t.Errorf("dsTypeName(0xFF) = %q, want %q", got, "unknown")
+	}
+}
+
+// recordingServer accumulates every batch pushed to it over PushSamples.
+type recordingServer struct {
+	mu      sync.Mutex
+	batches [][]*collectdv1.Sample
+}
+
+func (s *recordingServer) PushSamples(stream collectdv1.CollectdExporter_PushSamplesServer) error {
+	var batch []*collectdv1.Sample
+	for {
+		sample, err := stream.Recv()
+		if err == io.EOF {
+			s.mu.Lock()
+			s.batches = append(s.batches, batch)
+			s.mu.Unlock()
+			return stream.SendAndClose(&collectdv1.Ack{SamplesReceived: int64(len(batch))})
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, sample)
+	}
+}
+
+func (s *recordingServer) batchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func (s *recordingServer) lastBatchSize() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.batches) == 0 {
+		return -1
+	}
+	return len(s.batches[len(s.batches)-1])
+}
+
+// failingServer rejects every PushSamples call outright, to exercise
+// grpcSink's reconnect-on-failure path.
+type failingServer struct{}
+
+func (failingServer) PushSamples(stream collectdv1.CollectdExporter_PushSamplesServer) error {
+	return status.Error(codes.Unavailable, "forced failure")
+}
+
+func startTestServer(t *testing.T, srv collectdv1.CollectdExporterServer) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	grpcServer := grpc.NewServer()
+	collectdv1.RegisterCollectdExporterServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	return lis.Addr().String()
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition was not met before the deadline")
+}
+
+func TestGRPCSinkFlushesOnBatchSize(t *testing.T) {
+	server := &recordingServer{}
+	target := startTestServer(t, server)
+
+	sink := newGRPCSink(target, 3, time.Hour)
+	defer sink.invalidate()
+
+	for i := 0; i < 3; i++ {
+		if err := sink.Emit(Sample{Host: "h"}); err != nil {
+			t.Fatalf("Emit returned error: %v", err)
+		}
+	}
+
+	waitFor(t, 2*time.Second, func() bool { return server.batchCount() == 1 })
+	if got := server.lastBatchSize(); got != 3 {
+		t.Errorf("batch size = %d, want 3", got)
+	}
+}
+
+func TestGRPCSinkFlushesOnTicker(t *testing.T) {
+	server := &recordingServer{}
+	target := startTestServer(t, server)
+
+	sink := newGRPCSink(target, 1000, 50*time.Millisecond)
+	defer sink.invalidate()
+
+	if err := sink.Emit(Sample{Host: "h"}); err != nil {
+		t.Fatalf("Emit returned error: %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool { return server.batchCount() == 1 })
+	if got := server.lastBatchSize(); got != 1 {
+		t.Errorf("batch size = %d, want 1", got)
+	}
+}
+
+func TestGRPCSinkInvalidatesConnectionOnFailure(t *testing.T) {
+	target := startTestServer(t, failingServer{})
+
+	sink := newGRPCSink(target, 1, time.Hour)
+	defer sink.invalidate()
+
+	if err := sink.Emit(Sample{Host: "h"}); err != nil {
+		t.Fatalf("Emit returned error: %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		sink.connMu.Lock()
+		defer sink.connMu.Unlock()
+		return sink.client == nil && sink.conn == nil
+	})
+}
+
+// TestGRPCSinkClientConnBacksOffOnDialFailure exercises clientConn's backoff
+// bookkeeping directly, since a reachable-but-malformed target is the only
+// way to make grpc.NewClient itself fail synchronously (an unreachable
+// address still "dials" successfully - grpc-go connects lazily, and the
+// failure only shows up later on the stream).
+func TestGRPCSinkClientConnBacksOffOnDialFailure(t *testing.T) {
+	sink := &grpcSink{target: "\x00invalid-target"}
+
+	if _, err := sink.clientConn(); err == nil {
+		t.Fatal("clientConn returned no error for an invalid target")
+	}
+
+	sink.connMu.Lock()
+	backoff := sink.backoff
+	nextDialAt := sink.nextDialAt
+	sink.connMu.Unlock()
+
+	if backoff != grpcMinBackoff {
+		t.Errorf("backoff = %v, want %v after the first failed dial", backoff, grpcMinBackoff)
+	}
+	if !nextDialAt.After(time.Now()) {
+		t.Error("nextDialAt was not pushed into the future after a failed dial")
+	}
+
+	if _, err := sink.clientConn(); err == nil {
+		t.Fatal("clientConn returned no error while backing off")
+	}
+}
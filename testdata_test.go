@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// buildPart wraps payload in a PartHeader with the given type, as it would
+// appear on the wire.
+func buildPart(partType uint16, payload []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header[0:2], partType)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(payload)+4))
+	return append(header, payload...)
+}
+
+func buildStringPayload(s string) []byte {
+	return []byte(s)
+}
+
+func buildNumericPayload(value int64) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, value)
+	return buf.Bytes()
+}
+
+// buildValuesPayload builds the payload of a 0x0006 Values part: a
+// NumberOfValues header, one DS-type byte per value, then one typed value
+// per DS in collectd's wire encoding (big-endian for everything except
+// GAUGE, which is little-endian IEEE 754).
+func buildValuesPayload(dsTypes []byte, values []float64) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint16(len(dsTypes)))
+	buf.Write(dsTypes)
+	for i, dsType := range dsTypes {
+		switch dsType {
+		case DsTypeGauge:
+			binary.Write(buf, binary.LittleEndian, math.Float64bits(values[i]))
+		case DsTypeCounter:
+			binary.Write(buf, binary.BigEndian, uint64(values[i]))
+		case DsTypeDerive, DsTypeAbsolute:
+			binary.Write(buf, binary.BigEndian, int64(values[i]))
+		}
+	}
+	return buf.Bytes()
+}
+
+// buildDatagram assembles a minimal but realistic collectd datagram: host,
+// plugin, type, interval and a single-value Values part.
+func buildDatagram(host, plugin, typ string, interval int64, dsType byte, value float64) []byte {
+	datagram := new(bytes.Buffer)
+	datagram.Write(buildPart(0x0000, buildStringPayload(host)))
+	datagram.Write(buildPart(0x0002, buildStringPayload(plugin)))
+	datagram.Write(buildPart(0x0004, buildStringPayload(typ)))
+	datagram.Write(buildPart(0x0007, buildNumericPayload(interval)))
+	datagram.Write(buildPart(0x0006, buildValuesPayload([]byte{dsType}, []float64{value})))
+	return datagram.Bytes()
+}